@@ -7,17 +7,16 @@ package cmd
 
 import (
 	"context"
-	"crypto/sha256"
 	"crypto/tls"
-	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math/big"
-	"math/rand"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -57,10 +56,32 @@ type AddressKey struct {
 }
 
 type injectProcessor struct {
-	api      iotexapi.APIServiceClient
-	chainID  uint32
-	nonces   *ttl.Cache
-	accounts []*AddressKey
+	api       iotexapi.APIServiceClient
+	chainID   uint32
+	nonces    *ttl.Cache
+	accounts  []*AddressKey
+	workloads []Workload
+	metrics   *injectMetrics
+	rng       *seededRand
+
+	replay    []actionParams
+	replayIdx uint64
+
+	recorder    *os.File
+	recorderEnc *json.Encoder
+	recorderMu  sync.Mutex
+
+	tipMu      sync.Mutex
+	tipHeight  uint64
+	tipHash    string
+	inFlightMu sync.Mutex
+	inFlight   []inFlightAction
+
+	rateMu     sync.Mutex
+	currentAPS float64
+
+	windowSent   uint64
+	windowFailed uint64
 }
 
 func newInjectionProcessor() (*injectProcessor, error) {
@@ -89,9 +110,12 @@ func newInjectionProcessor() (*injectProcessor, error) {
 		return nil, err
 	}
 	p := &injectProcessor{
-		api:     api,
-		chainID: response.ChainMeta.ChainID,
-		nonces:  nonceCache,
+		api:        api,
+		chainID:    response.ChainMeta.ChainID,
+		nonces:     nonceCache,
+		currentAPS: float64(injectCfg.aps),
+		metrics:    newInjectMetrics(),
+		rng:        newSeededRand(injectCfg.seed),
 	}
 	if err = p.randAccounts(injectCfg.randAccounts); err != nil {
 		return p, err
@@ -101,6 +125,28 @@ func newInjectionProcessor() (*injectProcessor, error) {
 			return p, err
 		}
 	}
+	if injectCfg.scenarioPath != "" {
+		workloads, err := loadScenario(injectCfg.scenarioPath)
+		if err != nil {
+			return p, err
+		}
+		p.workloads = workloads
+	}
+	if injectCfg.replayPath != "" {
+		replay, err := loadReplay(injectCfg.replayPath)
+		if err != nil {
+			return p, err
+		}
+		p.replay = replay
+	}
+	if injectCfg.recordPath != "" {
+		recorder, err := openRecorder(injectCfg.recordPath)
+		if err != nil {
+			return p, err
+		}
+		p.recorder = recorder
+		p.recorderEnc = json.NewEncoder(recorder)
+	}
 	p.syncNonces(context.Background())
 	return p, nil
 }
@@ -182,17 +228,25 @@ func (p *injectProcessor) syncNoncesProcess(ctx context.Context) {
 }
 
 func (p *injectProcessor) syncNonces(ctx context.Context) {
+	p.checkReorg(ctx)
+
 	var addrPool []string
 	for _, v := range p.nonces.Keys() {
 		addrPool = append(addrPool, v.(string))
 	}
 	for _, addr := range addrPool {
+		var previous uint64
+		if val, ok := p.nonces.Get(addr); ok {
+			previous = val.(uint64)
+		}
 		err := backoff.Retry(func() error {
 			resp, err := p.api.GetAccount(ctx, &iotexapi.GetAccountRequest{Address: addr})
 			if err != nil {
 				return err
 			}
-			p.nonces.Set(addr, resp.GetAccountMeta().GetPendingNonce())
+			pending := resp.GetAccountMeta().GetPendingNonce()
+			p.metrics.recordNonceLag(addr, int64(pending)-int64(previous))
+			p.nonces.Set(addr, pending)
 			return nil
 		}, backoff.NewExponentialBackOff())
 		if err != nil {
@@ -214,11 +268,17 @@ func (p *injectProcessor) injectProcess(ctx context.Context) {
 
 	defer workers.Wait()
 	defer close(ticks)
-	interval := uint64(time.Second.Nanoseconds() / int64(injectCfg.aps))
-	began, count := time.Now(), uint64(0)
+	go p.governRate(ctx)
+
+	count := uint64(0)
 	for {
-		now, next := time.Now(), began.Add(time.Duration(count*interval))
-		time.Sleep(next.Sub(now))
+		timer := time.NewTimer(time.Duration(time.Second.Nanoseconds() / int64(p.aps())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
 		select {
 		case <-ctx.Done():
 			return
@@ -231,21 +291,93 @@ func (p *injectProcessor) injectProcess(ctx context.Context) {
 	}
 }
 
+// aps returns the current send rate picked by the AIMD governor.
+func (p *injectProcessor) aps() float64 {
+	p.rateMu.Lock()
+	defer p.rateMu.Unlock()
+	return p.currentAPS
+}
+
+func (p *injectProcessor) setAPS(aps float64) {
+	p.rateMu.Lock()
+	p.currentAPS = aps
+	p.rateMu.Unlock()
+}
+
+// recordOutcome feeds a single action's success/failure into the current AIMD control window.
+func (p *injectProcessor) recordOutcome(failed bool) {
+	atomic.AddUint64(&p.windowSent, 1)
+	if failed {
+		atomic.AddUint64(&p.windowFailed, 1)
+	}
+}
+
+// governRate is an AIMD controller that nudges currentAPS based on the failure/timeout ratio
+// observed since the last control window.
+func (p *injectProcessor) governRate(ctx context.Context) {
+	window := time.NewTicker(2 * time.Second)
+	defer window.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-window.C:
+			sent := atomic.SwapUint64(&p.windowSent, 0)
+			failed := atomic.SwapUint64(&p.windowFailed, 0)
+			if sent == 0 {
+				continue
+			}
+			ratio := float64(failed) / float64(sent)
+			aps := p.aps()
+			switch {
+			case ratio > injectCfg.highWater:
+				aps *= injectCfg.mdFactor
+			case ratio < injectCfg.lowWater:
+				aps += injectCfg.aimDelta
+			}
+			if aps < injectCfg.apsMin {
+				aps = injectCfg.apsMin
+			}
+			if aps > injectCfg.apsMax {
+				aps = injectCfg.apsMax
+			}
+			p.setAPS(aps)
+			log.L().Info("AIMD rate adjustment",
+				zap.Float64("aps", aps),
+				zap.Float64("failureRatio", ratio),
+				zap.Uint64("sent", sent),
+				zap.Uint64("failed", failed))
+		}
+	}
+}
+
 func (p *injectProcessor) inject(workers *sync.WaitGroup, ticks <-chan uint64) {
 	defer workers.Done()
 	for range ticks {
 		go func() {
-			caller, err := p.pickAction()
+			caller, ap, err := p.pickAction()
 			if err != nil {
 				log.L().Error("Failed to create an action", zap.Error(err))
+				if err != errSetCodeUnsupported {
+					p.recordOutcome(true)
+				}
+				return
 			}
 			var actionHash hash.Hash256
+			failed := false
 			bo := backoff.WithMaxRetries(backoff.NewConstantBackOff(injectCfg.retryInterval), injectCfg.retryNum)
-			if rerr := backoff.Retry(func() error {
+			callStart := time.Now()
+			rerr := backoff.Retry(func() error {
 				actionHash, err = caller.Call(context.Background())
 				return err
-			}, bo); rerr != nil {
+			}, bo)
+			p.metrics.recordCall(time.Since(callStart), rerr)
+			p.recordAction(ap, actionHash)
+			if rerr != nil {
 				log.L().Error("Failed to inject.", zap.Error(rerr))
+				failed = true
+			} else if ap != nil {
+				p.recordInFlight(ap, actionHash)
 			}
 
 			c := iotex.NewReadOnlyClient(p.api)
@@ -253,86 +385,173 @@ func (p *injectProcessor) inject(workers *sync.WaitGroup, ticks <-chan uint64) {
 			if injectCfg.checkReceipt {
 				time.Sleep(25 * time.Second)
 				var response *iotexapi.GetReceiptByActionResponse
-				if rerr := backoff.Retry(func() error {
+				receiptStart := time.Now()
+				rerr := backoff.Retry(func() error {
 					response, err = c.GetReceipt(actionHash).Call(context.Background())
 					return err
-				}, bo); rerr != nil {
+				}, bo)
+				if rerr != nil {
+					p.metrics.recordReceipt(time.Since(receiptStart), 0, rerr)
 					log.L().Error("Failed to get receipt.", zap.Error(rerr))
-				}
-				if response.ReceiptInfo.Receipt.Status != 1 {
-					log.L().Error("Receipt has failed status.", zap.Uint64("status", response.ReceiptInfo.Receipt.Status))
+					failed = true
+				} else {
+					p.metrics.recordReceipt(time.Since(receiptStart), response.ReceiptInfo.Receipt.Status, nil)
+					if response.ReceiptInfo.Receipt.Status != 1 {
+						log.L().Error("Receipt has failed status.", zap.Uint64("status", response.ReceiptInfo.Receipt.Status))
+						failed = true
+					}
 				}
 			}
+			p.recordOutcome(failed)
 		}()
 	}
 }
 
-func (p *injectProcessor) pickAction() (iotex.Caller, error) {
+// pickAction returns the next caller to submit, plus its actionParams when the caller came from
+// the --record/--replay-aware transfer/execution path (nil otherwise).
+func (p *injectProcessor) pickAction() (iotex.Caller, *actionParams, error) {
+	if len(p.workloads) > 0 {
+		w := p.pickWorkload()
+		sender := p.accounts[p.rng.Intn(len(p.accounts))]
+		caller, err := w.Build(p, sender)
+		return caller, nil, err
+	}
 	switch injectCfg.actionType {
 	case "transfer":
 		return p.transferCaller()
 	case "execution":
 		return p.executionCaller()
+	case "setcode":
+		caller, err := p.setCodeCaller()
+		return caller, nil, err
 	case "mixed":
-		if rand.Intn(2) == 0 {
+		if p.rng.Intn(2) == 0 {
 			return p.transferCaller()
 		}
 		return p.executionCaller()
+	case "mixed7702":
+		return p.mixed7702Caller()
 	default:
 		return p.transferCaller()
 	}
 }
 
-func (p *injectProcessor) executionCaller() (iotex.Caller, error) {
-	var nonce uint64
-	sender := p.accounts[rand.Intn(len(p.accounts))]
-	if val, ok := p.nonces.Get(sender.EncodedAddr); ok {
-		nonce = val.(uint64)
+// mixed7702Caller picks transfer, execution, or setcode by --mixed7702-*-weight.
+func (p *injectProcessor) mixed7702Caller() (iotex.Caller, *actionParams, error) {
+	total := injectCfg.mixed7702TransferWeight + injectCfg.mixed7702ExecutionWeight + injectCfg.mixed7702SetCodeWeight
+	if total <= 0 {
+		caller, err := p.setCodeCaller()
+		return caller, nil, err
+	}
+	roll := p.rng.Intn(total)
+	switch {
+	case roll < injectCfg.mixed7702TransferWeight:
+		return p.transferCaller()
+	case roll < injectCfg.mixed7702TransferWeight+injectCfg.mixed7702ExecutionWeight:
+		return p.executionCaller()
+	default:
+		caller, err := p.setCodeCaller()
+		return caller, nil, err
+	}
+}
+
+// executionCaller builds the built-in "addHash" contract-execution action from --record/--replay
+// actionParams.
+func (p *injectProcessor) executionCaller() (iotex.Caller, *actionParams, error) {
+	ap, err := p.nextActionParams("execution")
+	if err != nil {
+		return nil, nil, err
+	}
+	caller, err := p.buildExecutionCaller(ap)
+	return caller, ap, err
+}
+
+// buildExecutionCaller builds the caller for the "addHash" contract execution described by ap.
+func (p *injectProcessor) buildExecutionCaller(ap *actionParams) (iotex.Caller, error) {
+	sender, err := p.accountByAddress(ap.Sender)
+	if err != nil {
+		return nil, err
 	}
-	p.nonces.Set(sender.EncodedAddr, nonce+1)
 
 	operatorAccount, _ := account.PrivateKeyToAccount(sender.PriKey)
 	c := iotex.NewAuthedClient(p.api, p.chainID, operatorAccount)
-	address, _ := address.FromString(injectCfg.contract)
-	abiJSONVar, _ := abi.JSON(strings.NewReader(_abiStr))
-	contract := c.Contract(address, abiJSONVar)
-
-	data := rand.Int63()
-	var dataBuf = make([]byte, 8)
-	binary.BigEndian.PutUint64(dataBuf, uint64(data))
-	dataHash := sha256.Sum256(dataBuf)
+	contractAddr, err := address.FromString(injectCfg.contract)
+	if err != nil {
+		return nil, err
+	}
+	abiJSONVar, err := abi.JSON(strings.NewReader(_abiStr))
+	if err != nil {
+		return nil, err
+	}
+	contract := c.Contract(contractAddr, abiJSONVar)
+	gasPrice, err := apGasPrice(ap)
+	if err != nil {
+		return nil, err
+	}
 
-	caller := contract.Execute("addHash", uint64(time.Now().Unix()), hex.EncodeToString(dataHash[:])).
-		SetNonce(nonce).
+	return contract.Execute("addHash", uint64(ap.Timestamp), ap.Payload).
+		SetNonce(ap.Nonce).
 		SetAmount(injectCfg.executionAmount).
-		SetGasPrice(injectCfg.executionGasPrice).
-		SetGasLimit(injectCfg.executionGasLimit)
+		SetGasPrice(gasPrice).
+		SetGasLimit(ap.GasLimit), nil
+}
 
-	return caller, nil
+// apGasPrice parses the gas price recorded in ap.
+func apGasPrice(ap *actionParams) (*big.Int, error) {
+	gasPrice, ok := new(big.Int).SetString(ap.GasPrice, 10)
+	if !ok {
+		return nil, errors.Errorf("invalid gas price %q", ap.GasPrice)
+	}
+	return gasPrice, nil
 }
 
-func (p *injectProcessor) transferCaller() (iotex.SendActionCaller, error) {
-	var nonce uint64
-	sender := p.accounts[rand.Intn(len(p.accounts))]
-	if val, ok := p.nonces.Get(sender.EncodedAddr); ok {
-		nonce = val.(uint64)
+// transferCaller builds the built-in plain-transfer action from --record/--replay actionParams.
+func (p *injectProcessor) transferCaller() (iotex.SendActionCaller, *actionParams, error) {
+	ap, err := p.nextActionParams("transfer")
+	if err != nil {
+		return nil, nil, err
+	}
+	caller, err := p.buildTransferCaller(ap)
+	return caller, ap, err
+}
+
+// buildTransferCaller builds the caller for the plain transfer described by ap.
+func (p *injectProcessor) buildTransferCaller(ap *actionParams) (iotex.SendActionCaller, error) {
+	sender, err := p.accountByAddress(ap.Sender)
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := address.FromString(ap.Recipient)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := hex.DecodeString(ap.Payload)
+	if err != nil {
+		return nil, err
+	}
+	gasPrice, err := apGasPrice(ap)
+	if err != nil {
+		return nil, err
 	}
-	p.nonces.Set(sender.EncodedAddr, nonce+1)
 
 	operatorAccount, _ := account.PrivateKeyToAccount(sender.PriKey)
 	c := iotex.NewAuthedClient(p.api, p.chainID, operatorAccount)
+	return c.Transfer(recipient, injectCfg.transferAmount).
+		SetPayload(payload).
+		SetNonce(ap.Nonce).
+		SetGasPrice(gasPrice).
+		SetGasLimit(ap.GasLimit), nil
+}
 
-	recipient, _ := address.FromString(p.accounts[rand.Intn(len(p.accounts))].EncodedAddr)
-	data := rand.Int63()
-	var dataBuf = make([]byte, 8)
-	binary.BigEndian.PutUint64(dataBuf, uint64(data))
-	dataHash := sha256.Sum256(dataBuf)
-	caller := c.Transfer(recipient, injectCfg.transferAmount).
-		SetPayload(dataHash[:]).
-		SetNonce(nonce).
-		SetGasPrice(injectCfg.transferGasPrice).
-		SetGasLimit(injectCfg.transferGasLimit)
-	return caller, nil
+// errSetCodeUnsupported is returned by setCodeCaller. It's checked at the call site so a
+// mixed7702 run with setcode still enabled doesn't count these as chain-health failures in the
+// AIMD governor's ratio.
+var errSetCodeUnsupported = errors.New("setcode action type is not supported: iotex-antenna-go/v2 has no set-code/authorization action to submit it through")
+
+// setCodeCaller is a stub: iotex-antenna-go/v2 has no set-code/authorization action to submit an
+// EIP-7702 style batch through, so this isn't implementable against the real SDK yet.
+func (p *injectProcessor) setCodeCaller() (iotex.Caller, error) {
+	return nil, errSetCodeUnsupported
 }
 
 // injectCmd represents the inject command
@@ -369,6 +588,26 @@ var rawInjectCfg = struct {
 
 	randAccounts    int
 	loadTokenAmount string
+	scenarioPath    string
+
+	authListSize             int
+	mixed7702TransferWeight  int
+	mixed7702ExecutionWeight int
+	mixed7702SetCodeWeight   int
+
+	apsMin    float64
+	apsMax    float64
+	aimDelta  float64
+	mdFactor  float64
+	lowWater  float64
+	highWater float64
+
+	metricsAddr string
+	reportOut   string
+
+	seed       int64
+	recordPath string
+	replayPath string
 }{}
 
 var injectCfg = struct {
@@ -394,6 +633,26 @@ var injectCfg = struct {
 	insecure        bool
 	randAccounts    int
 	loadTokenAmount *big.Int
+	scenarioPath    string
+
+	authListSize             int
+	mixed7702TransferWeight  int
+	mixed7702ExecutionWeight int
+	mixed7702SetCodeWeight   int
+
+	apsMin    float64
+	apsMax    float64
+	aimDelta  float64
+	mdFactor  float64
+	lowWater  float64
+	highWater float64
+
+	metricsAddr string
+	reportOut   string
+
+	seed       int64
+	recordPath string
+	replayPath string
 }{}
 
 func inject(_ []string) string {
@@ -428,6 +687,26 @@ func inject(_ []string) string {
 	injectCfg.insecure = rawInjectCfg.insecure
 	injectCfg.randAccounts = rawInjectCfg.randAccounts
 	injectCfg.loadTokenAmount = loadTokenAmount
+	injectCfg.scenarioPath = rawInjectCfg.scenarioPath
+
+	injectCfg.authListSize = rawInjectCfg.authListSize
+	injectCfg.mixed7702TransferWeight = rawInjectCfg.mixed7702TransferWeight
+	injectCfg.mixed7702ExecutionWeight = rawInjectCfg.mixed7702ExecutionWeight
+	injectCfg.mixed7702SetCodeWeight = rawInjectCfg.mixed7702SetCodeWeight
+
+	injectCfg.apsMin = rawInjectCfg.apsMin
+	injectCfg.apsMax = rawInjectCfg.apsMax
+	injectCfg.aimDelta = rawInjectCfg.aimDelta
+	injectCfg.mdFactor = rawInjectCfg.mdFactor
+	injectCfg.lowWater = rawInjectCfg.lowWater
+	injectCfg.highWater = rawInjectCfg.highWater
+
+	injectCfg.metricsAddr = rawInjectCfg.metricsAddr
+	injectCfg.reportOut = rawInjectCfg.reportOut
+
+	injectCfg.seed = rawInjectCfg.seed
+	injectCfg.recordPath = rawInjectCfg.recordPath
+	injectCfg.replayPath = rawInjectCfg.replayPath
 
 	p, err := newInjectionProcessor()
 	if err != nil {
@@ -436,9 +715,18 @@ func inject(_ []string) string {
 
 	ctx, cancel := context.WithTimeout(context.Background(), injectCfg.duration)
 	defer cancel()
+	if injectCfg.metricsAddr != "" {
+		p.metrics.serve(ctx, injectCfg.metricsAddr)
+	}
+	began := time.Now()
 	go p.injectProcess(ctx)
 	go p.syncNoncesProcess(ctx)
 	<-ctx.Done()
+	if injectCfg.reportOut != "" {
+		if err := p.metrics.report(injectCfg.reportOut, time.Since(began)); err != nil {
+			log.L().Error("Failed to write run report.", zap.Error(err))
+		}
+	}
 	return ""
 }
 
@@ -459,11 +747,27 @@ func init() {
 	flag.DurationVar(&rawInjectCfg.retryInterval, "retry-interval", 1*time.Second, "sleep interval between two consecutive rpc retries")
 	flag.DurationVar(&rawInjectCfg.duration, "duration", 60*time.Hour, "duration when the injection will run")
 	flag.DurationVar(&rawInjectCfg.resetInterval, "reset-interval", 10*time.Second, "time interval to reset nonce counter")
-	flag.IntVar(&rawInjectCfg.aps, "aps", 30, "actions to be injected per second")
+	flag.IntVar(&rawInjectCfg.aps, "aps", 30, "starting actions-per-second rate for the AIMD governor to adapt from")
 	flag.IntVar(&rawInjectCfg.randAccounts, "rand-accounts", 20, "number of accounst to use")
 	flag.Uint64Var(&rawInjectCfg.workers, "workers", 10, "number of workers")
 	flag.BoolVar(&rawInjectCfg.insecure, "insecure", false, "insecure network")
 	flag.BoolVar(&rawInjectCfg.checkReceipt, "check-recipt", false, "check recept")
 	flag.StringVar(&rawInjectCfg.loadTokenAmount, "load-token-amount", "0", "init load how much token to inject accounts")
+	flag.StringVar(&rawInjectCfg.scenarioPath, "scenario", "", "path of a YAML scenario file declaring a weighted mix of workloads; overrides --action-type")
+	flag.IntVar(&rawInjectCfg.authListSize, "auth-list-size", 1, "number of authorization tuples per setcode action; not read, since setcode actions always fail with errSetCodeUnsupported")
+	flag.IntVar(&rawInjectCfg.mixed7702TransferWeight, "mixed7702-transfer-weight", 1, "relative weight of transfer actions in mixed7702 mode")
+	flag.IntVar(&rawInjectCfg.mixed7702ExecutionWeight, "mixed7702-execution-weight", 1, "relative weight of execution actions in mixed7702 mode")
+	flag.IntVar(&rawInjectCfg.mixed7702SetCodeWeight, "mixed7702-setcode-weight", 0, "relative weight of setcode actions in mixed7702 mode (opt-in: setcode is a stub that always errors until antenna-client gains real support)")
+	flag.Float64Var(&rawInjectCfg.apsMin, "aps-min", 1, "lower bound the AIMD governor will not throttle below")
+	flag.Float64Var(&rawInjectCfg.apsMax, "aps-max", 1000, "upper bound the AIMD governor will not ramp above")
+	flag.Float64Var(&rawInjectCfg.aimDelta, "aim-delta", 5, "additive increase applied to the send rate each control window when the failure ratio is low")
+	flag.Float64Var(&rawInjectCfg.mdFactor, "md-factor", 0.5, "multiplicative decrease applied to the send rate each control window when the failure ratio is high")
+	flag.Float64Var(&rawInjectCfg.lowWater, "low-water", 0.02, "failure/timeout ratio below which the send rate is increased")
+	flag.Float64Var(&rawInjectCfg.highWater, "high-water", 0.2, "failure/timeout ratio above which the send rate is decreased")
+	flag.StringVar(&rawInjectCfg.metricsAddr, "metrics-addr", "", "address to serve Prometheus /metrics on, e.g. :9091 (disabled if empty)")
+	flag.StringVar(&rawInjectCfg.reportOut, "report-out", "", "path to write a JSON run summary to on shutdown (disabled if empty)")
+	flag.Int64Var(&rawInjectCfg.seed, "seed", 1, "seed for the injector's deterministic random source")
+	flag.StringVar(&rawInjectCfg.recordPath, "record", "", "path to write an NDJSON transcript of every generated transfer/execution action (disabled if empty)")
+	flag.StringVar(&rawInjectCfg.replayPath, "replay", "", "path of an NDJSON transcript from --record to replay instead of generating new actions")
 	rootCmd.AddCommand(injectCmd)
 }
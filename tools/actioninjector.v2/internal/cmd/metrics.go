@@ -0,0 +1,226 @@
+// Copyright (c) 2019 IoTeX Foundation
+// This source code is provided 'as is' and no warranties are given as to title or non-infringement, merchantability
+// or fitness for purpose and, to the extent permitted by law, all liability for your use of the code is disclaimed.
+// This source code is governed by Apache License 2.0 that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/iotexproject/iotex-core/v2/pkg/log"
+)
+
+// injectMetrics holds Prometheus counters/histograms plus raw latency samples for the
+// per-run JSON report, shared by inject(), syncNonces(), and injectProcess().
+type injectMetrics struct {
+	registry *prometheus.Registry
+
+	actionsSubmitted prometheus.Counter
+	actionsConfirmed prometheus.Counter
+	callLatency      prometheus.Histogram
+	receiptLatency   prometheus.Histogram
+	errorsByClass    *prometheus.CounterVec
+	receiptStatus    *prometheus.CounterVec
+	nonceLag         *prometheus.GaugeVec
+
+	confirmedCount int64
+
+	mu               sync.Mutex
+	callLatencies    []time.Duration
+	receiptLatencies []time.Duration
+	errorBreakdown   map[string]int
+}
+
+func newInjectMetrics() *injectMetrics {
+	m := &injectMetrics{
+		registry: prometheus.NewRegistry(),
+		actionsSubmitted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "inject_actions_submitted_total",
+			Help: "Total number of actions submitted through caller.Call.",
+		}),
+		actionsConfirmed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "inject_actions_confirmed_total",
+			Help: "Total number of actions observed with a successful receipt.",
+		}),
+		callLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "inject_call_latency_seconds",
+			Help:    "Latency of caller.Call RPCs.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		receiptLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "inject_receipt_latency_seconds",
+			Help:    "Latency of GetReceipt RPCs.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		errorsByClass: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "inject_errors_total",
+			Help: "Count of RPC failures, by gRPC status code.",
+		}, []string{"class"}),
+		receiptStatus: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "inject_receipt_status_total",
+			Help: "Distribution of on-chain receipt statuses.",
+		}, []string{"status"}),
+		nonceLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "inject_nonce_lag",
+			Help: "Difference between the server's pending nonce and the last locally tracked nonce, by account.",
+		}, []string{"account"}),
+		errorBreakdown: make(map[string]int),
+	}
+	m.registry.MustRegister(
+		m.actionsSubmitted,
+		m.actionsConfirmed,
+		m.callLatency,
+		m.receiptLatency,
+		m.errorsByClass,
+		m.receiptStatus,
+		m.nonceLag,
+	)
+	return m
+}
+
+// serve starts the Prometheus /metrics endpoint on addr and runs until ctx is done.
+func (m *injectMetrics) serve(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.L().Error("Metrics server stopped unexpectedly.", zap.Error(err))
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+}
+
+// errorClass classifies err by gRPC status code, falling back to "Unknown" for non-gRPC errors.
+func errorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	if st, ok := status.FromError(err); ok {
+		return st.Code().String()
+	}
+	return codes.Unknown.String()
+}
+
+// recordCall records the outcome of one action submission through caller.Call; latency covers
+// every retry of that submission.
+func (m *injectMetrics) recordCall(latency time.Duration, err error) {
+	m.actionsSubmitted.Inc()
+	m.callLatency.Observe(latency.Seconds())
+	m.mu.Lock()
+	m.callLatencies = append(m.callLatencies, latency)
+	if err != nil {
+		class := errorClass(err)
+		m.errorBreakdown[class]++
+		m.mu.Unlock()
+		m.errorsByClass.WithLabelValues(class).Inc()
+		return
+	}
+	m.mu.Unlock()
+}
+
+// recordReceipt records the outcome of one receipt lookup through GetReceipt (latency covers every
+// retry of that lookup), and the on-chain status of a successfully retrieved receipt.
+func (m *injectMetrics) recordReceipt(latency time.Duration, status uint64, err error) {
+	m.receiptLatency.Observe(latency.Seconds())
+	m.mu.Lock()
+	m.receiptLatencies = append(m.receiptLatencies, latency)
+	if err != nil {
+		class := errorClass(err)
+		m.errorBreakdown[class]++
+		m.mu.Unlock()
+		m.errorsByClass.WithLabelValues(class).Inc()
+		return
+	}
+	m.mu.Unlock()
+	m.receiptStatus.WithLabelValues(fmt.Sprintf("%d", status)).Inc()
+	if status == 1 {
+		m.actionsConfirmed.Inc()
+		atomic.AddInt64(&m.confirmedCount, 1)
+	}
+}
+
+// recordNonceLag records the gap between the server's pending nonce and what was locally tracked
+// for addr before this sync tick.
+func (m *injectMetrics) recordNonceLag(addr string, lag int64) {
+	m.nonceLag.WithLabelValues(addr).Set(float64(lag))
+}
+
+// runReport is the shape written to --report-out on shutdown.
+type runReport struct {
+	TotalSent      int            `json:"totalSent"`
+	TotalConfirmed int            `json:"totalConfirmed"`
+	TPSAchieved    float64        `json:"tpsAchieved"`
+	CallLatencyMS  latencyReport  `json:"callLatencyMs"`
+	ErrorBreakdown map[string]int `json:"errorBreakdown"`
+}
+
+type latencyReport struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(p * float64(len(sorted)-1))
+	return float64(sorted[idx].Microseconds()) / 1000
+}
+
+// report summarizes the run's latencies and error counts and writes them as JSON to path.
+func (m *injectMetrics) report(path string, duration time.Duration) error {
+	m.mu.Lock()
+	latencies := append([]time.Duration(nil), m.callLatencies...)
+	errorBreakdown := make(map[string]int, len(m.errorBreakdown))
+	for k, v := range m.errorBreakdown {
+		errorBreakdown[k] = v
+	}
+	m.mu.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	r := runReport{
+		TotalSent:      len(latencies),
+		TotalConfirmed: int(atomic.LoadInt64(&m.confirmedCount)),
+		TPSAchieved:    float64(len(latencies)) / duration.Seconds(),
+		CallLatencyMS: latencyReport{
+			P50: percentile(latencies, 0.50),
+			P95: percentile(latencies, 0.95),
+			P99: percentile(latencies, 0.99),
+		},
+		ErrorBreakdown: errorBreakdown,
+	}
+
+	out, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal run report")
+	}
+	if err := os.WriteFile(filepath.Clean(path), out, 0644); err != nil {
+		return errors.Wrap(err, "failed to write run report")
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2019 IoTeX Foundation
+// This source code is provided 'as is' and no warranties are given as to title or non-infringement, merchantability
+// or fitness for purpose and, to the extent permitted by law, all liability for your use of the code is disclaimed.
+// This source code is governed by Apache License 2.0 that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/iotexproject/go-pkgs/hash"
+	"github.com/iotexproject/iotex-antenna-go/v2/iotex"
+	"github.com/iotexproject/iotex-proto/golang/iotexapi"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/iotexproject/iotex-core/v2/pkg/log"
+)
+
+// inFlightRingSize bounds how many submitted actions are remembered for reorg reconciliation;
+// older entries are evicted as new ones are recorded.
+const inFlightRingSize = 4096
+
+// inFlightAction is one submitted action awaiting confirmation, kept around so it can be rebuilt
+// and resubmitted if a reorg orphans it.
+type inFlightAction struct {
+	Params     *actionParams
+	ActionHash hash.Hash256
+	SubmitTime time.Time
+}
+
+// recordInFlight appends a submitted action to the ring buffer, evicting the oldest entry once
+// inFlightRingSize is exceeded.
+func (p *injectProcessor) recordInFlight(ap *actionParams, actionHash hash.Hash256) {
+	p.inFlightMu.Lock()
+	defer p.inFlightMu.Unlock()
+	p.inFlight = append(p.inFlight, inFlightAction{Params: ap, ActionHash: actionHash, SubmitTime: time.Now()})
+	if len(p.inFlight) > inFlightRingSize {
+		p.inFlight = p.inFlight[len(p.inFlight)-inFlightRingSize:]
+	}
+}
+
+// AdjustNonce nudges the locally tracked nonce for addr by delta, clamping at 0. It's exposed so
+// external test drivers can realign nonces after a simulated chain revert.
+func (p *injectProcessor) AdjustNonce(addr string, delta int64) {
+	var current int64
+	if val, ok := p.nonces.Get(addr); ok {
+		current = int64(val.(uint64))
+	}
+	current += delta
+	if current < 0 {
+		current = 0
+	}
+	p.nonces.Set(addr, uint64(current))
+}
+
+// checkReorg detects a reorg by rollback (height going backwards) or by the hash at the
+// previously observed height changing under a since-advanced tip, and reconciles in-flight
+// actions against it.
+func (p *injectProcessor) checkReorg(ctx context.Context) {
+	meta, err := p.api.GetChainMeta(ctx, &iotexapi.GetChainMetaRequest{})
+	if err != nil {
+		log.L().Error("Failed to fetch chain meta for reorg detection.", zap.Error(err))
+		return
+	}
+	height := meta.ChainMeta.Height
+
+	p.tipMu.Lock()
+	prevHeight, prevHash := p.tipHeight, p.tipHash
+	p.tipMu.Unlock()
+
+	checkHeight := height
+	if prevHeight != 0 && prevHeight < checkHeight {
+		checkHeight = prevHeight
+	}
+	hashAtCheckHeight := p.blockHash(ctx, checkHeight)
+
+	reorged := prevHash != "" && hashAtCheckHeight != "" &&
+		(height < prevHeight || (checkHeight == prevHeight && hashAtCheckHeight != prevHash))
+
+	var tipHash string
+	if checkHeight == height {
+		tipHash = hashAtCheckHeight
+	} else {
+		tipHash = p.blockHash(ctx, height)
+	}
+
+	p.tipMu.Lock()
+	p.tipHeight, p.tipHash = height, tipHash
+	p.tipMu.Unlock()
+
+	if !reorged {
+		return
+	}
+	log.L().Warn("Reorg detected, reconciling in-flight actions.",
+		zap.Uint64("previousHeight", prevHeight),
+		zap.Uint64("newHeight", height))
+	p.reconcileInFlight(ctx)
+}
+
+// blockHash fetches the hash of the block at height, returning "" if it can't be determined.
+func (p *injectProcessor) blockHash(ctx context.Context, height uint64) string {
+	resp, err := p.api.GetBlockMetas(ctx, &iotexapi.GetBlockMetasRequest{
+		Lookup: &iotexapi.GetBlockMetasRequest_ByIndex{
+			ByIndex: &iotexapi.GetBlockMetasByIndexRequest{Start: height, Count: 1},
+		},
+	})
+	if err != nil || len(resp.BlkMetas) == 0 {
+		return ""
+	}
+	return resp.BlkMetas[0].Hash
+}
+
+// reconcileInFlight requeries receipts for every action still in the ring buffer and drops the
+// ones that confirmed; whatever remains is orphaned by the reorg and gets resubmitted with a
+// freshly synced nonce.
+func (p *injectProcessor) reconcileInFlight(ctx context.Context) {
+	p.inFlightMu.Lock()
+	pending := make([]inFlightAction, len(p.inFlight))
+	copy(pending, p.inFlight)
+	p.inFlight = nil
+	p.inFlightMu.Unlock()
+
+	c := iotex.NewReadOnlyClient(p.api)
+	var orphaned []inFlightAction
+	for _, action := range pending {
+		resp, err := c.GetReceipt(action.ActionHash).Call(ctx)
+		if err == nil && resp.ReceiptInfo.Receipt.Status == 1 {
+			continue
+		}
+		orphaned = append(orphaned, action)
+	}
+	if len(orphaned) == 0 {
+		return
+	}
+	log.L().Warn("Orphaned actions found after reorg; resubmitting with refreshed nonces.",
+		zap.Int("orphanedCount", len(orphaned)))
+
+	nextNonce := make(map[string]uint64, len(orphaned))
+	for _, action := range orphaned {
+		addr := action.Params.Sender
+		if _, ok := nextNonce[addr]; !ok {
+			resp, err := p.api.GetAccount(ctx, &iotexapi.GetAccountRequest{Address: addr})
+			if err != nil {
+				log.L().Error("Failed to refresh nonce before reinjecting orphaned action.", zap.Error(err), zap.String("addr", addr))
+				continue
+			}
+			nextNonce[addr] = resp.GetAccountMeta().GetPendingNonce()
+		}
+		p.reinject(ctx, action.Params, nextNonce[addr])
+		nextNonce[addr]++
+		p.nonces.Set(addr, nextNonce[addr])
+	}
+}
+
+// reinject rebuilds the action described by ap with the given nonce and resubmits it.
+func (p *injectProcessor) reinject(ctx context.Context, ap *actionParams, nonce uint64) {
+	refreshed := *ap
+	refreshed.Nonce = nonce
+
+	var caller iotex.Caller
+	var err error
+	switch refreshed.Kind {
+	case "transfer":
+		caller, err = p.buildTransferCaller(&refreshed)
+	case "execution":
+		caller, err = p.buildExecutionCaller(&refreshed)
+	default:
+		err = errors.Errorf("cannot rebuild action of kind %q for reinjection", refreshed.Kind)
+	}
+	if err != nil {
+		log.L().Error("Failed to rebuild orphaned action for reinjection.", zap.Error(err))
+		return
+	}
+
+	callStart := time.Now()
+	actionHash, err := caller.Call(ctx)
+	p.metrics.recordCall(time.Since(callStart), err)
+	if err != nil {
+		log.L().Error("Failed to reinject orphaned action.", zap.Error(err))
+		return
+	}
+	p.recordAction(&refreshed, actionHash)
+	p.recordInFlight(&refreshed, actionHash)
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2019 IoTeX Foundation
+// This source code is provided 'as is' and no warranties are given as to title or non-infringement, merchantability
+// or fitness for purpose and, to the extent permitted by law, all liability for your use of the code is disclaimed.
+// This source code is governed by Apache License 2.0 that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iotexproject/go-pkgs/hash"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/iotexproject/iotex-core/v2/pkg/log"
+)
+
+// seededRand is a per-processor, concurrency-safe source of randomness seeded from --seed.
+type seededRand struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newSeededRand(seed int64) *seededRand {
+	return &seededRand{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (s *seededRand) Intn(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Intn(n)
+}
+
+func (s *seededRand) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Int63()
+}
+
+func (s *seededRand) Int63n(n int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Int63n(n)
+}
+
+func (s *seededRand) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Read(p)
+}
+
+// actionParams is the recipe behind one generated transfer or execution action, captured to
+// --record and read back verbatim from --replay.
+type actionParams struct {
+	Kind       string `json:"kind"` // "transfer" or "execution"
+	Sender     string `json:"sender"`
+	Nonce      uint64 `json:"nonce"`
+	Recipient  string `json:"recipient,omitempty"`
+	Payload    string `json:"payload"`
+	Timestamp  int64  `json:"timestamp,omitempty"`
+	GasLimit   uint64 `json:"gasLimit"`
+	GasPrice   string `json:"gasPrice"`
+	ActionHash string `json:"actionHash,omitempty"`
+}
+
+// loadReplay reads a --record transcript back into an ordered slice of actionParams.
+func loadReplay(path string) ([]actionParams, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open replay transcript")
+	}
+	defer f.Close()
+
+	var params []actionParams
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var ap actionParams
+		if err := json.Unmarshal(line, &ap); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal replay transcript line")
+		}
+		params = append(params, ap)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read replay transcript")
+	}
+	return params, nil
+}
+
+// openRecorder creates --record, truncating any existing file, for writing newline-delimited JSON
+// action records.
+func openRecorder(path string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open record transcript")
+	}
+	return f, nil
+}
+
+// nextActionParams produces the recipe for the next action of the given kind: drawn from the
+// --replay transcript if one is loaded, otherwise freshly generated from p.rng.
+func (p *injectProcessor) nextActionParams(kind string) (*actionParams, error) {
+	if p.replay != nil {
+		idx := atomic.AddUint64(&p.replayIdx, 1) - 1
+		if idx >= uint64(len(p.replay)) {
+			return nil, errors.New("replay transcript exhausted")
+		}
+		ap := p.replay[idx]
+		if ap.Kind != kind {
+			return nil, errors.Errorf("replay transcript kind mismatch at index %d: want %q, got %q", idx, kind, ap.Kind)
+		}
+		return &ap, nil
+	}
+
+	sender := p.accounts[p.rng.Intn(len(p.accounts))]
+	nonce := p.nextNonce(sender)
+
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, uint64(p.rng.Int63()))
+	dataHash := sha256.Sum256(payload)
+
+	ap := &actionParams{
+		Kind:    kind,
+		Sender:  sender.EncodedAddr,
+		Nonce:   nonce,
+		Payload: hex.EncodeToString(dataHash[:]),
+	}
+	switch kind {
+	case "transfer":
+		ap.Recipient = p.accounts[p.rng.Intn(len(p.accounts))].EncodedAddr
+		ap.GasLimit = injectCfg.transferGasLimit
+		ap.GasPrice = injectCfg.transferGasPrice.String()
+	case "execution":
+		ap.Timestamp = time.Now().Unix()
+		ap.GasLimit = injectCfg.executionGasLimit
+		ap.GasPrice = injectCfg.executionGasPrice.String()
+	}
+	return ap, nil
+}
+
+// accountByAddress finds a loaded account by its encoded address, used to resolve the sender
+// recorded in a replay transcript back to its signing key.
+func (p *injectProcessor) accountByAddress(addr string) (*AddressKey, error) {
+	for _, a := range p.accounts {
+		if a.EncodedAddr == addr {
+			return a, nil
+		}
+	}
+	return nil, errors.Errorf("unknown account %s", addr)
+}
+
+// recordAction appends ap, stamped with the resulting action hash, to the --record transcript.
+func (p *injectProcessor) recordAction(ap *actionParams, actionHash hash.Hash256) {
+	if p.recorder == nil || ap == nil {
+		return
+	}
+	ap.ActionHash = hex.EncodeToString(actionHash[:])
+
+	p.recorderMu.Lock()
+	defer p.recorderMu.Unlock()
+	if err := p.recorderEnc.Encode(ap); err != nil {
+		log.L().Error("Failed to write action record.", zap.Error(err))
+	}
+}
@@ -0,0 +1,432 @@
+// Copyright (c) 2019 IoTeX Foundation
+// This source code is provided 'as is' and no warranties are given as to title or non-infringement, merchantability
+// or fitness for purpose and, to the extent permitted by law, all liability for your use of the code is disclaimed.
+// This source code is governed by Apache License 2.0 that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/iotexproject/iotex-address/address"
+	"github.com/iotexproject/iotex-antenna-go/v2/account"
+	"github.com/iotexproject/iotex-antenna-go/v2/iotex"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Workload is a kind of traffic the injector can generate, picked by weighted random selection
+// each tick. Built-ins cover transfer/execution/ERC-20/generic-ABI traffic; a scenario file can
+// declare any mix of them.
+type Workload interface {
+	// Name identifies the workload in logs and in the scenario file.
+	Name() string
+	// Build constructs the next caller to submit on behalf of sender.
+	Build(p *injectProcessor, sender *AddressKey) (iotex.Caller, error)
+	// Weight is the workload's relative share of the weighted random selection.
+	Weight() int
+}
+
+// scenario is the top-level shape of a --scenario YAML file: a traffic mix of weighted workloads.
+type scenario struct {
+	Workloads []workloadSpec `yaml:"workloads"`
+}
+
+// workloadSpec declares one workload entry in a scenario file.
+type workloadSpec struct {
+	Type     string    `yaml:"type"`
+	Name     string    `yaml:"name"`
+	Weight   int       `yaml:"weight"`
+	Contract string    `yaml:"contract"`
+	ABIPath  string    `yaml:"abiPath"`
+	Method   string    `yaml:"method"`
+	Args     []argSpec `yaml:"args"`
+	Amount   string    `yaml:"amount"`
+}
+
+// argSpec declares how to generate one positional argument to a contract call each time the
+// workload fires.
+type argSpec struct {
+	// Gen selects the generator: "constant", "random-int", "random-bytes", "sequential", or
+	// "from-account-pool".
+	Gen   string `yaml:"gen"`
+	Value string `yaml:"value"`
+	Min   int64  `yaml:"min"`
+	Max   int64  `yaml:"max"`
+	Size  int    `yaml:"size"`
+}
+
+// sequence backs the "sequential" arg generator; shared across calls to a workload, which run
+// concurrently across --workers, so the counter is atomic.
+type sequence struct {
+	next int64
+}
+
+func (s *sequence) generate() int64 {
+	return atomic.AddInt64(&s.next, 1) - 1
+}
+
+// addressToCommon converts an IoTeX bech32 address to the go-ethereum common.Address that
+// abi.Pack requires for an "address"-typed argument.
+func addressToCommon(encoded string) (common.Address, error) {
+	addr, err := address.FromString(encoded)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return common.BytesToAddress(addr.Bytes()), nil
+}
+
+// generate produces the next value for a positional contract-call argument, typed to match t so
+// abi.Pack accepts it.
+func (a *argSpec) generate(p *injectProcessor, seq *sequence, t abi.Type) (interface{}, error) {
+	switch a.Gen {
+	case "constant":
+		return convertArg(a.Value, t)
+	case "random-int":
+		lo, hi := a.Min, a.Max
+		v := lo
+		if hi > lo {
+			v = lo + p.rng.Int63n(hi-lo)
+		}
+		return intArg(v, t), nil
+	case "random-bytes":
+		size := a.Size
+		if size <= 0 {
+			size = 32
+		}
+		buf := make([]byte, size)
+		if _, err := p.rng.Read(buf); err != nil {
+			return nil, errors.Wrap(err, "failed to generate random bytes arg")
+		}
+		if t.T == abi.StringTy {
+			return hex.EncodeToString(buf), nil
+		}
+		if t.T == abi.FixedBytesTy {
+			arr := reflect.New(t.GetType()).Elem()
+			reflect.Copy(arr, reflect.ValueOf(buf))
+			return arr.Interface(), nil
+		}
+		return buf, nil
+	case "sequential":
+		return intArg(seq.generate(), t), nil
+	case "from-account-pool":
+		addr := p.accounts[p.rng.Intn(len(p.accounts))].EncodedAddr
+		if t.T != abi.AddressTy {
+			return addr, nil
+		}
+		return addressToCommon(addr)
+	default:
+		return nil, errors.Errorf("unknown arg generator %q", a.Gen)
+	}
+}
+
+// intArg packs v as the Go type abi.Pack expects for integer type t: a native fixed-width type for
+// 8, 16, 32 and 64 bits, *big.Int for every other width (uint256/int256, uint24 and the like).
+func intArg(v int64, t abi.Type) interface{} {
+	if t.T != abi.UintTy && t.T != abi.IntTy {
+		return v
+	}
+	if t.Size != 8 && t.Size != 16 && t.Size != 32 && t.Size != 64 {
+		if t.T == abi.UintTy {
+			return new(big.Int).SetUint64(uint64(v))
+		}
+		return big.NewInt(v)
+	}
+	switch {
+	case t.T == abi.UintTy && t.Size <= 8:
+		return uint8(v)
+	case t.T == abi.UintTy && t.Size <= 16:
+		return uint16(v)
+	case t.T == abi.UintTy && t.Size <= 32:
+		return uint32(v)
+	case t.T == abi.UintTy:
+		return uint64(v)
+	case t.Size <= 8:
+		return int8(v)
+	case t.Size <= 16:
+		return int16(v)
+	case t.Size <= 32:
+		return int32(v)
+	default:
+		return v
+	}
+}
+
+// convertArg converts a.Value, read as a plain string from the scenario file, to the Go type t
+// requires.
+func convertArg(value string, t abi.Type) (interface{}, error) {
+	switch t.T {
+	case abi.AddressTy:
+		return addressToCommon(value)
+	case abi.BytesTy, abi.FixedBytesTy:
+		b, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+		if err != nil || t.T == abi.BytesTy {
+			return b, err
+		}
+		arr := reflect.New(t.GetType()).Elem()
+		reflect.Copy(arr, reflect.ValueOf(b))
+		return arr.Interface(), nil
+	case abi.BoolTy:
+		return value == "true", nil
+	case abi.UintTy, abi.IntTy:
+		n, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, errors.Errorf("invalid integer arg %q", value)
+		}
+		if t.Size > 64 {
+			return n, nil
+		}
+		return intArg(n.Int64(), t), nil
+	default:
+		return value, nil
+	}
+}
+
+// loadScenario reads and validates a --scenario YAML file into a weighted set of workloads.
+func loadScenario(path string) ([]Workload, error) {
+	raw, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read scenario file")
+	}
+	var s scenario
+	if err := yaml.Unmarshal(raw, &s); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal scenario file")
+	}
+	if len(s.Workloads) == 0 {
+		return nil, errors.New("scenario file declares no workloads")
+	}
+
+	workloads := make([]Workload, 0, len(s.Workloads))
+	for _, spec := range s.Workloads {
+		w, err := newWorkload(spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to build workload %q", spec.Name)
+		}
+		workloads = append(workloads, w)
+	}
+	return workloads, nil
+}
+
+// newWorkload builds the concrete Workload described by spec.
+func newWorkload(spec workloadSpec) (Workload, error) {
+	if spec.Weight <= 0 {
+		spec.Weight = 1
+	}
+	switch spec.Type {
+	case "transfer":
+		return &transferWorkload{name: spec.Name, weight: spec.Weight}, nil
+	case "execution":
+		return &executionWorkload{name: spec.Name, weight: spec.Weight}, nil
+	case "erc20-transfer":
+		if spec.Contract == "" {
+			return nil, errors.New("erc20-transfer workload requires contract")
+		}
+		return &erc20TransferWorkload{name: spec.Name, weight: spec.Weight, contract: spec.Contract}, nil
+	case "abi-call":
+		if spec.Contract == "" || spec.ABIPath == "" || spec.Method == "" {
+			return nil, errors.New("abi-call workload requires contract, abiPath and method")
+		}
+		abiBytes, err := os.ReadFile(filepath.Clean(spec.ABIPath))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read ABI file")
+		}
+		abiJSON, err := abi.JSON(strings.NewReader(string(abiBytes)))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse ABI file")
+		}
+		return &abiCallWorkload{
+			name:     spec.Name,
+			weight:   spec.Weight,
+			contract: spec.Contract,
+			method:   spec.Method,
+			args:     spec.Args,
+			abiJSON:  abiJSON,
+			seq:      &sequence{},
+		}, nil
+	default:
+		return nil, errors.Errorf("unknown workload type %q", spec.Type)
+	}
+}
+
+// pickWorkload selects a workload from p.workloads by weighted random selection.
+func (p *injectProcessor) pickWorkload() Workload {
+	total := 0
+	for _, w := range p.workloads {
+		total += w.Weight()
+	}
+	roll := p.rng.Intn(total)
+	for _, w := range p.workloads {
+		if roll < w.Weight() {
+			return w
+		}
+		roll -= w.Weight()
+	}
+	return p.workloads[len(p.workloads)-1]
+}
+
+// nextNonce returns and reserves the next nonce for sender.
+func (p *injectProcessor) nextNonce(sender *AddressKey) uint64 {
+	var nonce uint64
+	if val, ok := p.nonces.Get(sender.EncodedAddr); ok {
+		nonce = val.(uint64)
+	}
+	p.nonces.Set(sender.EncodedAddr, nonce+1)
+	return nonce
+}
+
+// randomPayloadHash is the dataHash-from-random-bytes idiom shared by transfer and execution.
+func randomPayloadHash(p *injectProcessor) []byte {
+	data := p.rng.Int63()
+	var dataBuf = make([]byte, 8)
+	binary.BigEndian.PutUint64(dataBuf, uint64(data))
+	dataHash := sha256.Sum256(dataBuf)
+	return dataHash[:]
+}
+
+// transferWorkload is the built-in plain-transfer workload, equivalent to --action-type transfer.
+type transferWorkload struct {
+	name   string
+	weight int
+}
+
+func (w *transferWorkload) Name() string { return w.name }
+func (w *transferWorkload) Weight() int  { return w.weight }
+
+func (w *transferWorkload) Build(p *injectProcessor, sender *AddressKey) (iotex.Caller, error) {
+	nonce := p.nextNonce(sender)
+	operatorAccount, _ := account.PrivateKeyToAccount(sender.PriKey)
+	c := iotex.NewAuthedClient(p.api, p.chainID, operatorAccount)
+	recipient, err := address.FromString(p.accounts[p.rng.Intn(len(p.accounts))].EncodedAddr)
+	if err != nil {
+		return nil, err
+	}
+	return c.Transfer(recipient, injectCfg.transferAmount).
+		SetPayload(randomPayloadHash(p)).
+		SetNonce(nonce).
+		SetGasPrice(injectCfg.transferGasPrice).
+		SetGasLimit(injectCfg.transferGasLimit), nil
+}
+
+// executionWorkload is the built-in "addHash" contract-execution workload, equivalent to
+// --action-type execution.
+type executionWorkload struct {
+	name   string
+	weight int
+}
+
+func (w *executionWorkload) Name() string { return w.name }
+func (w *executionWorkload) Weight() int  { return w.weight }
+
+func (w *executionWorkload) Build(p *injectProcessor, sender *AddressKey) (iotex.Caller, error) {
+	nonce := p.nextNonce(sender)
+	operatorAccount, _ := account.PrivateKeyToAccount(sender.PriKey)
+	c := iotex.NewAuthedClient(p.api, p.chainID, operatorAccount)
+	contractAddr, err := address.FromString(injectCfg.contract)
+	if err != nil {
+		return nil, err
+	}
+	abiJSONVar, err := abi.JSON(strings.NewReader(_abiStr))
+	if err != nil {
+		return nil, err
+	}
+	contract := c.Contract(contractAddr, abiJSONVar)
+	dataHash := randomPayloadHash(p)
+	return contract.Execute("addHash", uint64(time.Now().Unix()), hex.EncodeToString(dataHash)).
+		SetNonce(nonce).
+		SetAmount(injectCfg.executionAmount).
+		SetGasPrice(injectCfg.executionGasPrice).
+		SetGasLimit(injectCfg.executionGasLimit), nil
+}
+
+// erc20TransferWorkload calls the standard ERC-20 transfer(address,uint256) method on contract.
+type erc20TransferWorkload struct {
+	name     string
+	weight   int
+	contract string
+}
+
+const _erc20ABIStr = `[{"constant":false,"inputs":[{"name":"_to","type":"address"},{"name":"_value","type":"uint256"}],"name":"transfer","outputs":[{"name":"","type":"bool"}],"type":"function"}]`
+
+func (w *erc20TransferWorkload) Name() string { return w.name }
+func (w *erc20TransferWorkload) Weight() int  { return w.weight }
+
+func (w *erc20TransferWorkload) Build(p *injectProcessor, sender *AddressKey) (iotex.Caller, error) {
+	nonce := p.nextNonce(sender)
+	operatorAccount, _ := account.PrivateKeyToAccount(sender.PriKey)
+	c := iotex.NewAuthedClient(p.api, p.chainID, operatorAccount)
+	contractAddr, err := address.FromString(w.contract)
+	if err != nil {
+		return nil, err
+	}
+	abiJSONVar, err := abi.JSON(strings.NewReader(_erc20ABIStr))
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := addressToCommon(p.accounts[p.rng.Intn(len(p.accounts))].EncodedAddr)
+	if err != nil {
+		return nil, err
+	}
+	contract := c.Contract(contractAddr, abiJSONVar)
+	return contract.Execute("transfer", recipient, injectCfg.transferAmount).
+		SetNonce(nonce).
+		SetGasPrice(injectCfg.executionGasPrice).
+		SetGasLimit(injectCfg.executionGasLimit), nil
+}
+
+// abiCallWorkload calls an arbitrary method on a contract whose ABI is loaded from abiPath,
+// with arguments produced fresh from args on every call.
+type abiCallWorkload struct {
+	name     string
+	weight   int
+	contract string
+	method   string
+	args     []argSpec
+	abiJSON  abi.ABI
+	seq      *sequence
+}
+
+func (w *abiCallWorkload) Name() string { return w.name }
+func (w *abiCallWorkload) Weight() int  { return w.weight }
+
+func (w *abiCallWorkload) Build(p *injectProcessor, sender *AddressKey) (iotex.Caller, error) {
+	nonce := p.nextNonce(sender)
+	operatorAccount, _ := account.PrivateKeyToAccount(sender.PriKey)
+	c := iotex.NewAuthedClient(p.api, p.chainID, operatorAccount)
+	contractAddr, err := address.FromString(w.contract)
+	if err != nil {
+		return nil, err
+	}
+
+	method, ok := w.abiJSON.Methods[w.method]
+	if !ok {
+		return nil, errors.Errorf("method %q not found in ABI", w.method)
+	}
+	args := make([]interface{}, 0, len(w.args))
+	for i := range w.args {
+		if i >= len(method.Inputs) {
+			return nil, errors.Errorf("method %q takes %d args, got %d in scenario", w.method, len(method.Inputs), len(w.args))
+		}
+		v, err := w.args[i].generate(p, w.seq, method.Inputs[i].Type)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	contract := c.Contract(contractAddr, w.abiJSON)
+	return contract.Execute(w.method, args...).
+		SetNonce(nonce).
+		SetGasPrice(injectCfg.executionGasPrice).
+		SetGasLimit(injectCfg.executionGasLimit), nil
+}